@@ -0,0 +1,160 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/bodgit/sevenzip"
+)
+
+// archiveIterator walks the CSV entries of an uploaded archive one at a time, so
+// handlePost doesn't need a format-specific switch for every archive type it supports.
+type archiveIterator interface {
+	// Next returns the next entry's name and content, or io.EOF when exhausted.
+	Next() (name string, rc io.ReadCloser, err error)
+}
+
+// archiveSource is the minimal random-access read surface detectArchiveType/
+// newArchiveIterator need. *bytes.Reader satisfies it directly for in-memory uploads;
+// fileArchiveSource adapts an *os.File for uploads reassembled on disk, so a multi-GB
+// archive never has to be buffered whole into a []byte just to be sniffed and unpacked.
+type archiveSource interface {
+	io.ReaderAt
+	Size() int64
+}
+
+// fileArchiveSource adapts an *os.File into an archiveSource. size is passed in rather
+// than stat'd lazily since callers already know it (e.g. the tus Upload-Length).
+type fileArchiveSource struct {
+	*os.File
+	size int64
+}
+
+func (f fileArchiveSource) Size() int64 { return f.size }
+
+// detectArchiveType sniffs magic bytes to pick a format when the `type` query
+// parameter is empty.
+func detectArchiveType(src archiveSource) string {
+	head := make([]byte, 6)
+	n, _ := src.ReadAt(head, 0)
+	head = head[:n]
+
+	switch {
+	case len(head) >= 4 && bytes.Equal(head[:4], []byte{0x50, 0x4B, 0x03, 0x04}):
+		return "zip"
+	case len(head) >= 6 && bytes.Equal(head, []byte{0x37, 0x7A, 0xBC, 0xAF, 0x27, 0x1C}):
+		return "7z"
+	case len(head) >= 2 && head[0] == 0x1F && head[1] == 0x8B:
+		return "tar.gz"
+	}
+
+	if src.Size() >= 262 {
+		ustar := make([]byte, 5)
+		if n, _ := src.ReadAt(ustar, 257); n == 5 && string(ustar) == "ustar" {
+			return "tar"
+		}
+	}
+	return ""
+}
+
+// newArchiveIterator builds the archiveIterator for the given type. Adding a new
+// format (e.g. tar.bz2) means adding one case here and one iterator implementation,
+// not touching handlePost.
+func newArchiveIterator(archiveType string, src archiveSource) (archiveIterator, error) {
+	switch archiveType {
+	case "zip", "":
+		zr, err := zip.NewReader(src, src.Size())
+		if err != nil {
+			return nil, fmt.Errorf("invalid zip: %w", err)
+		}
+		return &zipIterator{files: zr.File}, nil
+	case "tar":
+		return &tarIterator{tr: tar.NewReader(io.NewSectionReader(src, 0, src.Size()))}, nil
+	case "tar.gz", "tgz":
+		gr, err := gzip.NewReader(io.NewSectionReader(src, 0, src.Size()))
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip: %w", err)
+		}
+		return &tarIterator{tr: tar.NewReader(gr)}, nil
+	case "7z":
+		zr, err := sevenzip.NewReader(src, src.Size())
+		if err != nil {
+			return nil, fmt.Errorf("invalid 7z: %w", err)
+		}
+		return &sevenZipIterator{files: zr.File}, nil
+	default:
+		return nil, fmt.Errorf("unsupported archive type %q", archiveType)
+	}
+}
+
+// zipIterator implements archiveIterator over archive/zip entries.
+type zipIterator struct {
+	files []*zip.File
+	idx   int
+}
+
+func (it *zipIterator) Next() (string, io.ReadCloser, error) {
+	for it.idx < len(it.files) {
+		f := it.files[it.idx]
+		it.idx++
+		if !strings.HasSuffix(strings.ToLower(f.Name), ".csv") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return "", nil, err
+		}
+		return f.Name, rc, nil
+	}
+	return "", nil, io.EOF
+}
+
+// tarIterator implements archiveIterator over archive/tar entries. The caller is
+// responsible for wrapping tr's source with a gzip.Reader beforehand for tar.gz/tgz.
+type tarIterator struct {
+	tr *tar.Reader
+}
+
+func (it *tarIterator) Next() (string, io.ReadCloser, error) {
+	for {
+		header, err := it.tr.Next()
+		if err == io.EOF {
+			return "", nil, io.EOF
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		if !strings.HasSuffix(strings.ToLower(header.Name), ".csv") {
+			continue
+		}
+		return header.Name, io.NopCloser(it.tr), nil
+	}
+}
+
+// sevenZipIterator implements archiveIterator over github.com/bodgit/sevenzip entries.
+type sevenZipIterator struct {
+	files []*sevenzip.File
+	idx   int
+}
+
+func (it *sevenZipIterator) Next() (string, io.ReadCloser, error) {
+	for it.idx < len(it.files) {
+		f := it.files[it.idx]
+		it.idx++
+		if !strings.HasSuffix(strings.ToLower(f.Name), ".csv") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return "", nil, err
+		}
+		return f.Name, rc, nil
+	}
+	return "", nil, io.EOF
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/bodgit/sevenzip"
+)
+
+func buildTarGzCSV(t *testing.T, name, csvContent string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+
+	if err := tw.WriteHeader(&tar.Header{Name: name, Size: int64(len(csvContent)), Mode: 0o644}); err != nil {
+		t.Fatalf("write tar header: %v", err)
+	}
+	if _, err := tw.Write([]byte(csvContent)); err != nil {
+		t.Fatalf("write tar content: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("close tar: %v", err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatalf("close gzip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func buildTarCSV(t *testing.T, name, csvContent string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	if err := tw.WriteHeader(&tar.Header{Name: name, Size: int64(len(csvContent)), Mode: 0o644}); err != nil {
+		t.Fatalf("write tar header: %v", err)
+	}
+	if _, err := tw.Write([]byte(csvContent)); err != nil {
+		t.Fatalf("write tar content: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("close tar: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func readAllEntries(t *testing.T, it archiveIterator) map[string]string {
+	t.Helper()
+	got := map[string]string{}
+	for {
+		name, rc, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("iterator.Next: %v", err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("read entry %s: %v", name, err)
+		}
+		got[name] = string(b)
+	}
+	return got
+}
+
+func TestDetectArchiveTypeAndIterateTarGz(t *testing.T) {
+	const csvContent = "name,category,price\nApple,Fruit,100\n"
+	body := buildTarGzCSV(t, "prices.csv", csvContent)
+	src := bytes.NewReader(body)
+
+	if got := detectArchiveType(src); got != "tar.gz" {
+		t.Fatalf("detectArchiveType = %q, want %q", got, "tar.gz")
+	}
+
+	it, err := newArchiveIterator("tar.gz", src)
+	if err != nil {
+		t.Fatalf("newArchiveIterator: %v", err)
+	}
+	entries := readAllEntries(t, it)
+	if entries["prices.csv"] != csvContent {
+		t.Errorf("prices.csv = %q, want %q", entries["prices.csv"], csvContent)
+	}
+}
+
+func TestDetectArchiveTypeAutoDetectsTgzAlias(t *testing.T) {
+	const csvContent = "name,category,price\nBanana,Fruit,50\n"
+	body := buildTarGzCSV(t, "prices.csv", csvContent)
+	src := bytes.NewReader(body)
+
+	// The tgz alias isn't sniffable from magic bytes alone (it shares tar.gz's gzip
+	// signature); detectArchiveType should still resolve it, and the iterator should
+	// accept either spelling.
+	it, err := newArchiveIterator("tgz", src)
+	if err != nil {
+		t.Fatalf("newArchiveIterator: %v", err)
+	}
+	entries := readAllEntries(t, it)
+	if entries["prices.csv"] != csvContent {
+		t.Errorf("prices.csv = %q, want %q", entries["prices.csv"], csvContent)
+	}
+}
+
+func TestDetectArchiveTypeAndIterateTar(t *testing.T) {
+	const csvContent = "name,category,price\nCarrot,Veg,30\n"
+	body := buildTarCSV(t, "data/prices.csv", csvContent)
+	src := bytes.NewReader(body)
+
+	// Plain (non-gzipped) tar has no distinctive magic bytes at offset 0 — it's only
+	// identifiable by the "ustar" marker at byte 257, which detectArchiveType checks.
+	if got := detectArchiveType(src); got != "tar" {
+		t.Fatalf("detectArchiveType = %q, want %q", got, "tar")
+	}
+
+	// An empty archiveType defaults the switch to the zip case, so a plain tar only
+	// iterates correctly when the caller passes the type detectArchiveType returned.
+	if _, err := newArchiveIterator("", src); err == nil {
+		t.Fatal("expected newArchiveIterator(\"\", ...) to fail parsing a tar as zip")
+	}
+
+	it, err := newArchiveIterator(detectArchiveType(src), src)
+	if err != nil {
+		t.Fatalf("newArchiveIterator: %v", err)
+	}
+	entries := readAllEntries(t, it)
+	if entries["data/prices.csv"] != csvContent {
+		t.Errorf("data/prices.csv = %q, want %q", entries["data/prices.csv"], csvContent)
+	}
+}
+
+// TestDetectArchiveTypeAndIterate7z round-trips testdata/sample.7z (borrowed from
+// bodgit/sevenzip's own BSD-licensed test fixtures, since this environment has no 7z
+// encoder available to build one from scratch) through detectArchiveType/
+// newArchiveIterator. The fixture's entries ("foo", "bar") aren't CSV-named, so this
+// doesn't exercise the ".csv" suffix filter, but it does exercise the real risk area:
+// magic-byte sniffing and decompressing real 7z data through the archiveSource
+// (*bytes.Reader/*os.File) plumbing introduced when ingestArchive moved off []byte.
+func TestDetectArchiveTypeAndIterate7z(t *testing.T) {
+	body, err := os.ReadFile("testdata/sample.7z")
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	src := bytes.NewReader(body)
+
+	if got := detectArchiveType(src); got != "7z" {
+		t.Fatalf("detectArchiveType = %q, want %q", got, "7z")
+	}
+
+	it, err := newArchiveIterator("7z", src)
+	if err != nil {
+		t.Fatalf("newArchiveIterator: %v", err)
+	}
+	entries := readAllEntries(t, it)
+	if len(entries) != 0 {
+		t.Fatalf("expected no .csv-suffixed entries in the fixture, got %v", entries)
+	}
+
+	// Confirm the container itself decoded correctly (not just that our suffix filter
+	// swallowed an error) by reading the raw entries through the underlying library.
+	zr, err := sevenzip.NewReader(src, src.Size())
+	if err != nil {
+		t.Fatalf("open raw 7z: %v", err)
+	}
+	want := map[string]string{"foo": "foo\n", "bar": "bar\n"}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("open entry %s: %v", f.Name, err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("read entry %s: %v", f.Name, err)
+		}
+		if string(b) != want[f.Name] {
+			t.Errorf("entry %s = %q, want %q", f.Name, b, want[f.Name])
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// priceRow is the shape streamed out of every export encoder.
+type priceRow struct {
+	Name       string `json:"name"`
+	Category   string `json:"category"`
+	Price      int    `json:"price"`
+	CreateDate string `json:"create_date"`
+}
+
+// rowEncoder writes the filtered prices result set row-by-row, so handleGet never
+// has to buffer the full export in memory.
+type rowEncoder interface {
+	ContentType() string
+	WriteHeader() error
+	WriteRow(row priceRow) error
+	Close() error
+}
+
+func negotiateFormat(r *http.Request) string {
+	if out := strings.ToLower(r.URL.Query().Get("out")); out != "" {
+		return out
+	}
+	switch accept := r.Header.Get("Accept"); {
+	case strings.Contains(accept, "application/x-ndjson"):
+		return "jsonl"
+	case strings.Contains(accept, "application/json"):
+		return "json"
+	case strings.Contains(accept, "text/tab-separated-values"):
+		return "tsv"
+	default:
+		return "csv"
+	}
+}
+
+func newRowEncoder(format string, w io.Writer) (rowEncoder, error) {
+	switch format {
+	case "csv", "text/csv":
+		return &csvRowEncoder{cw: csv.NewWriter(w)}, nil
+	case "tsv", "text/tab-separated-values":
+		cw := csv.NewWriter(w)
+		cw.Comma = '\t'
+		return &csvRowEncoder{cw: cw}, nil
+	case "json", "application/json":
+		return &jsonArrayEncoder{w: w}, nil
+	case "jsonl", "application/x-ndjson":
+		return &ndjsonEncoder{enc: json.NewEncoder(w)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported out format %q", format)
+	}
+}
+
+// csvRowEncoder backs both CSV (comma) and TSV (tab) output.
+type csvRowEncoder struct {
+	cw *csv.Writer
+}
+
+func (e *csvRowEncoder) ContentType() string { return "text/csv" }
+
+func (e *csvRowEncoder) WriteHeader() error {
+	return e.cw.Write([]string{"name", "category", "price", "create_date"})
+}
+
+func (e *csvRowEncoder) WriteRow(row priceRow) error {
+	if err := e.cw.Write([]string{row.Name, row.Category, strconv.Itoa(row.Price), row.CreateDate}); err != nil {
+		return err
+	}
+	e.cw.Flush()
+	return e.cw.Error()
+}
+
+func (e *csvRowEncoder) Close() error {
+	e.cw.Flush()
+	return e.cw.Error()
+}
+
+// jsonArrayEncoder writes the result set as a single JSON array: `[{...},{...}]`.
+type jsonArrayEncoder struct {
+	w       io.Writer
+	started bool
+}
+
+func (e *jsonArrayEncoder) ContentType() string { return "application/json" }
+
+func (e *jsonArrayEncoder) WriteHeader() error {
+	_, err := e.w.Write([]byte("["))
+	return err
+}
+
+func (e *jsonArrayEncoder) WriteRow(row priceRow) error {
+	if e.started {
+		if _, err := e.w.Write([]byte(",")); err != nil {
+			return err
+		}
+	}
+	e.started = true
+	b, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(b)
+	return err
+}
+
+func (e *jsonArrayEncoder) Close() error {
+	_, err := e.w.Write([]byte("]"))
+	return err
+}
+
+// ndjsonEncoder writes one JSON object per line (application/x-ndjson).
+type ndjsonEncoder struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonEncoder) ContentType() string { return "application/x-ndjson" }
+func (e *ndjsonEncoder) WriteHeader() error  { return nil }
+func (e *ndjsonEncoder) WriteRow(row priceRow) error {
+	return e.enc.Encode(row)
+}
+func (e *ndjsonEncoder) Close() error { return nil }
+
+// flushableWriter mirrors the Flush method shared by csv/gzip/bufio writers, so the
+// streaming loop can flush the whole pipeline without type-switching on every layer.
+type flushableWriter interface {
+	Flush() error
+}
+
+func handleGet(w http.ResponseWriter, r *http.Request) {
+	logger := loggerFrom(r.Context())
+	format := negotiateFormat(r)
+	encoding := strings.ToLower(r.URL.Query().Get("encoding"))
+
+	query, args := buildPricesQuery(r)
+	logger.Info("executing export query", "query", query, "args", args)
+
+	var rows *sql.Rows
+	err := timeDBQuery("select", func() error {
+		var err error
+		rows, err = db.Query(query, args...)
+		return err
+	})
+	if err != nil {
+		logger.Error("export query failed", "error", err)
+		http.Error(w, "query error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	ext := map[string]string{"csv": "csv", "tsv": "tsv", "json": "json", "jsonl": "ndjson"}[format]
+	if ext == "" {
+		ext = "csv"
+	}
+
+	var container io.Writer = w
+	var zw *zip.Writer
+	var gw *gzip.Writer
+
+	switch encoding {
+	case "zip":
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=data.%s.zip", ext))
+		zw = zip.NewWriter(w)
+		entry, err := zw.Create("data." + ext)
+		if err != nil {
+			logger.Error("create zip entry failed", "error", err)
+			http.Error(w, "export error", http.StatusInternalServerError)
+			return
+		}
+		container = entry
+	case "gzip":
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=data.%s.gz", ext))
+		gw = gzip.NewWriter(w)
+		container = gw
+	default:
+		contentTypes := map[string]string{
+			"csv": "text/csv", "tsv": "text/tab-separated-values",
+			"json": "application/json", "jsonl": "application/x-ndjson",
+		}
+		if ct, ok := contentTypes[format]; ok {
+			w.Header().Set("Content-Type", ct)
+		}
+		w.Header().Set("Transfer-Encoding", "chunked")
+	}
+
+	encoder, err := newRowEncoder(format, container)
+	if err != nil {
+		logger.Error("unsupported export format", "error", err)
+		http.Error(w, "unsupported out format", http.StatusBadRequest)
+		return
+	}
+
+	if err := encoder.WriteHeader(); err != nil {
+		logger.Error("write export header failed", "error", err)
+	}
+
+	flusher, _ := w.(http.Flusher)
+	rowCount := 0
+	for rows.Next() {
+		var rowPrice int
+		var name, category string
+		var createDate sql.NullTime
+
+		if err := rows.Scan(&name, &category, &rowPrice, &createDate); err != nil {
+			logger.Error("row scan failed", "error", err)
+			continue
+		}
+
+		dateStr := ""
+		if createDate.Valid {
+			dateStr = createDate.Time.Format("2006-01-02")
+		}
+
+		if err := encoder.WriteRow(priceRow{Name: name, Category: category, Price: rowPrice, CreateDate: dateStr}); err != nil {
+			logger.Error("write export row failed", "error", err)
+		}
+		rowCount++
+
+		// Периодически сбрасываем буферы по всему пайплайну, чтобы большие выгрузки
+		// стримились клиенту, а не копились целиком в памяти сервера.
+		if rowCount%500 == 0 {
+			if fw, ok := container.(flushableWriter); ok {
+				fw.Flush()
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.Error("rows iteration failed", "error", err)
+	}
+
+	if err := encoder.Close(); err != nil {
+		logger.Error("close encoder failed", "error", err)
+	}
+	if gw != nil {
+		if err := gw.Close(); err != nil {
+			logger.Error("close gzip failed", "error", err)
+		}
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			logger.Error("close zip failed", "error", err)
+		}
+	}
+
+	logger.Info("export completed", "rows", rowCount, "format", format, "encoding", encoding)
+}
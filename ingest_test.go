@@ -0,0 +1,97 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func buildZipCSV(t *testing.T, csvContent string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	f, err := zw.Create("prices.csv")
+	if err != nil {
+		t.Fatalf("create zip entry: %v", err)
+	}
+	if _, err := f.Write([]byte(csvContent)); err != nil {
+		t.Fatalf("write zip entry: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("close zip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// TestIngestArchiveMergeStagingIsNullSafe exercises the COPY/staging/merge path against a
+// mocked DB (no Postgres container needed) and pins down the NULL-safe ON CONFLICT target:
+// a row with an empty create_date must still be mergeable via the COALESCE expression index.
+func TestIngestArchiveMergeStagingIsNullSafe(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer mockDB.Close()
+
+	// The worker-pool pipeline in processCSV validates rows concurrently, so the order
+	// distinct valid rows reach copyStmt.Exec isn't guaranteed.
+	mock.MatchExpectationsInOrder(false)
+
+	csvContent := "id,name,category,price,create_date\n" +
+		"1,Apple,Fruit,100,2024-01-01\n" +
+		"2,Banana,Fruit,50,\n" +
+		"3,,Fruit,50,\n" + // empty name -> rejected
+		"4,Carrot,Veg,notaprice,\n" // invalid price -> rejected
+	body := buildZipCSV(t, csvContent)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TEMP TABLE prices_staging")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectPrepare(`COPY "prices_staging"`)
+	mock.ExpectExec(`COPY "prices_staging"`).WithArgs("Apple", "Fruit", 100, "2024-01-01").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`COPY "prices_staging"`).WithArgs("Banana", "Fruit", 50, nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`COPY "prices_staging"`).WithArgs().WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(
+		"ON CONFLICT (name, category, (COALESCE(create_date, '-infinity'::date))) DO NOTHING")).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	tx, err := mockDB.Begin()
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+
+	result, err := ingestArchive(context.Background(), tx, "zip", bytes.NewReader(body), nil)
+	if err != nil {
+		t.Fatalf("ingestArchive: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	if result.rowsProcessed != 4 {
+		t.Errorf("rowsProcessed = %d, want 4", result.rowsProcessed)
+	}
+	if result.staged != 2 {
+		t.Errorf("staged = %d, want 2", result.staged)
+	}
+	if result.inserted != 2 {
+		t.Errorf("inserted = %d, want 2", result.inserted)
+	}
+	if result.totalPrice != 150 {
+		t.Errorf("totalPrice = %d, want 150", result.totalPrice)
+	}
+	if !result.categories["Fruit"] {
+		t.Errorf("expected category Fruit to be recorded")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
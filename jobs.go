@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type jobState string
+
+const (
+	jobStatePending jobState = "pending"
+	jobStateRunning jobState = "running"
+	jobStateDone    jobState = "done"
+	jobStateFailed  jobState = "failed"
+)
+
+// Job tracks one async ingestion triggered by POST /api/v0/prices?async=true.
+// It's persisted to the jobs table after every state change so progress polling
+// (and the job itself, via ingestArchive's onProgress) survives a server restart.
+type Job struct {
+	ID            string   `json:"id"`
+	State         jobState `json:"state"`
+	ReceivedBytes int      `json:"received_bytes"`
+	ParsedRows    int      `json:"parsed_rows"`
+	InsertedRows  int      `json:"inserted_rows"`
+	Error         string   `json:"error,omitempty"`
+}
+
+func ensureJobsTable() {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS jobs (
+		id TEXT PRIMARY KEY,
+		state TEXT NOT NULL,
+		received_bytes INTEGER NOT NULL DEFAULT 0,
+		parsed_rows INTEGER NOT NULL DEFAULT 0,
+		inserted_rows INTEGER NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT ''
+	)`)
+	if err != nil {
+		log.Printf("Ошибка создания таблицы jobs: %v", err)
+	}
+}
+
+// recoverOrphanedJobs marks any job left pending/running by a previous process as
+// failed. Job progress is persisted to the jobs table, but the archive driving
+// ingestArchive only ever lived in the runIngestJob goroutine's memory (or a temp file
+// cleaned up alongside it), so a job interrupted by a restart can never resume — without
+// this sweep it's stuck reporting "running" forever to anyone polling
+// GET /api/v0/prices/jobs/{id}.
+func recoverOrphanedJobs() {
+	res, err := db.Exec(`
+		UPDATE jobs SET state = $1, error = $2
+		WHERE state IN ($3, $4)`,
+		jobStateFailed, "interrupted by server restart", jobStatePending, jobStateRunning)
+	if err != nil {
+		log.Printf("Ошибка восстановления зависших джоб: %v", err)
+		return
+	}
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		log.Printf("Помечено как failed зависших джоб после рестарта: %d", n)
+	}
+}
+
+func newJobID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand.Read практически никогда не возвращает ошибку на Linux;
+		// падать из-за id джобы не стоит, используем текущее время как запасной вариант
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+func saveJob(job *Job) {
+	_, err := db.Exec(`
+		INSERT INTO jobs (id, state, received_bytes, parsed_rows, inserted_rows, error)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (id) DO UPDATE SET
+			state = EXCLUDED.state,
+			received_bytes = EXCLUDED.received_bytes,
+			parsed_rows = EXCLUDED.parsed_rows,
+			inserted_rows = EXCLUDED.inserted_rows,
+			error = EXCLUDED.error`,
+		job.ID, job.State, job.ReceivedBytes, job.ParsedRows, job.InsertedRows, job.Error)
+	if err != nil {
+		log.Printf("Save job %s error: %v", job.ID, err)
+	}
+}
+
+func loadJob(id string) (*Job, error) {
+	job := &Job{ID: id}
+	err := db.QueryRow(`
+		SELECT state, received_bytes, parsed_rows, inserted_rows, error
+		FROM jobs WHERE id = $1`, id).
+		Scan(&job.State, &job.ReceivedBytes, &job.ParsedRows, &job.InsertedRows, &job.Error)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// startIngestJob records a new job row and runs the ingestion in the background,
+// returning immediately with the job in its pending state. cleanup, if non-nil, runs
+// once the job reaches a terminal state — callers ingesting from a temp file (a
+// completed resumable upload) use it to close and remove that file.
+func startIngestJob(queryType string, src archiveSource, cleanup func()) *Job {
+	job := &Job{ID: newJobID(), State: jobStatePending, ReceivedBytes: int(src.Size())}
+	saveJob(job)
+
+	go runIngestJob(job, queryType, src, cleanup)
+
+	return job
+}
+
+func runIngestJob(job *Job, queryType string, src archiveSource, cleanup func()) {
+	if cleanup != nil {
+		defer cleanup()
+	}
+
+	// Джоба выполняется уже после того, как HTTP-обработчик вернул ответ клиенту,
+	// поэтому request id совпадает с id самой джобы — общий логгер всё равно даёт
+	// единый ключ для поиска по логам.
+	ctx := context.WithValue(context.Background(), requestIDKey, job.ID)
+
+	job.State = jobStateRunning
+	saveJob(job)
+
+	tx, err := db.Begin()
+	if err != nil {
+		job.State = jobStateFailed
+		job.Error = err.Error()
+		saveJob(job)
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := ingestArchive(ctx, tx, queryType, src, func(rowsProcessed, staged int) {
+		job.ParsedRows = rowsProcessed
+		job.InsertedRows = staged
+		saveJob(job)
+	})
+	if err != nil {
+		job.State = jobStateFailed
+		job.Error = err.Error()
+		job.ParsedRows = result.rowsProcessed
+		saveJob(job)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		job.State = jobStateFailed
+		job.Error = err.Error()
+		saveJob(job)
+		return
+	}
+
+	rowsIngestedTotal.Add(float64(result.inserted))
+	rowsRejectedTotal.WithLabelValues("duplicate").Add(float64(result.staged - result.inserted))
+
+	job.State = jobStateDone
+	job.ParsedRows = result.rowsProcessed
+	job.InsertedRows = result.inserted
+	saveJob(job)
+}
+
+// handleGetJob serves GET /api/v0/prices/jobs/{id}.
+func handleGetJob(w http.ResponseWriter, r *http.Request) {
+	logger := loggerFrom(r.Context())
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/v0/prices/jobs/")
+	if id == "" {
+		http.Error(w, "missing job id", http.StatusBadRequest)
+		return
+	}
+
+	job, err := loadJob(id)
+	if err != nil {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(job); err != nil {
+		logger.Error("job json encode failed", "error", err)
+	}
+}
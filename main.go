@@ -1,8 +1,6 @@
 package main
 
 import (
-	"archive/tar"
-	"archive/zip"
 	"bytes"
 	"context"
 	"database/sql"
@@ -11,13 +9,16 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 type Stats struct {
@@ -31,6 +32,8 @@ type Stats struct {
 var db *sql.DB
 
 func main() {
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+
 	connStr := os.Getenv("DATABASE_URL")
 	if connStr == "" {
 		connStr = "host=localhost port=5432 user=validator password=val1dat0r dbname=project-sem-1 sslmode=disable"
@@ -55,8 +58,15 @@ func main() {
 
 	// Проверяем существующую структуру таблицы
 	checkAndCreateTable()
-
-	http.HandleFunc("/api/v0/prices", pricesHandler)
+	ensureJobsTable()
+	recoverOrphanedJobs()
+
+	http.HandleFunc("/api/v0/prices", withLogging(pricesHandler))
+	http.HandleFunc("/api/v0/prices/report", withLogging(handleReport))
+	http.HandleFunc("/api/v0/prices/jobs/", withLogging(handleGetJob))
+	http.HandleFunc("/api/v0/prices/uploads", withLogging(handleCreateUpload))
+	http.HandleFunc("/api/v0/prices/uploads/", withLogging(handleUploadChunk))
+	http.HandleFunc("/metrics", handleMetrics)
 	log.Println("Слушаем на :8080")
 	log.Fatal(http.ListenAndServe(":8080", nil))
 }
@@ -135,6 +145,21 @@ func checkAndCreateTable() {
 			}
 		}
 	}
+
+	// Уникальный индекс нужен для ON CONFLICT при мёрже из staging-таблицы. Индекс по
+	// голым колонкам не ловит дубликаты с NULL create_date (в Postgres NULL не равен
+	// NULL даже в уникальном индексе), поэтому сравниваем по COALESCE, где NULL-даты
+	// схлопываются в одно значение. Старый индекс по голым колонкам удаляем явно: CREATE
+	// INDEX IF NOT EXISTS не заменит существующий индекс с тем же именем, даже если его
+	// определение отличается.
+	if _, err := db.Exec(`DROP INDEX IF EXISTS prices_name_category_date_idx`); err != nil {
+		log.Printf("Ошибка удаления устаревшего уникального индекса: %v", err)
+	}
+	if _, err := db.Exec(`
+		CREATE UNIQUE INDEX IF NOT EXISTS prices_name_category_date_coalesce_idx
+		ON prices (name, category, COALESCE(create_date, '-infinity'::date))`); err != nil {
+		log.Printf("Ошибка создания уникального индекса: %v", err)
+	}
 }
 
 func pricesHandler(w http.ResponseWriter, r *http.Request) {
@@ -149,17 +174,19 @@ func pricesHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func handlePost(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := loggerFrom(ctx)
 	queryType := r.URL.Query().Get("type")
 
 	if err := r.ParseMultipartForm(10 << 20); err != nil {
-		log.Printf("ParseMultipartForm error: %v", err)
+		logger.Error("parse multipart form failed", "error", err)
 		http.Error(w, "multipart error", http.StatusBadRequest)
 		return
 	}
 
 	file, _, err := r.FormFile("file")
 	if err != nil {
-		log.Printf("FormFile error: %v", err)
+		logger.Error("form file missing", "error", err)
 		http.Error(w, "file missing", http.StatusBadRequest)
 		return
 	}
@@ -167,108 +194,203 @@ func handlePost(w http.ResponseWriter, r *http.Request) {
 
 	body, err := io.ReadAll(file)
 	if err != nil {
-		log.Printf("ReadAll error: %v", err)
+		logger.Error("read upload body failed", "error", err)
 		http.Error(w, "read error", http.StatusBadRequest)
 		return
 	}
 
-	totalRowsProcessed := 0
-	totalItemsInserted := 0
-	duplicatesCount := 0
-	totalPrice := 0
-	categories := make(map[string]bool)
-	seenItems := make(map[string]bool)
+	src := bytes.NewReader(body)
+	archiveBytesTotal.WithLabelValues(effectiveArchiveType(queryType, src)).Add(float64(src.Size()))
 
-	// Обработка ZIP архива
-	if queryType == "zip" || queryType == "" {
-		zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
-		if err != nil {
-			log.Printf("ZIP error: %v", err)
-			http.Error(w, "invalid zip", http.StatusBadRequest)
-			return
+	if r.URL.Query().Get("async") == "true" {
+		job := startIngestJob(queryType, src, nil)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(job)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		logger.Error("begin tx failed", "error", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	// Если обработка прервётся до явного Commit, откатываем всё, что успели скопировать
+	defer tx.Rollback()
+
+	result, err := ingestArchive(ctx, tx, queryType, src, nil)
+	if err != nil {
+		logger.Error("ingest failed", "error", err)
+		status := http.StatusBadRequest
+		if result.internal {
+			status = http.StatusInternalServerError
 		}
+		http.Error(w, err.Error(), status)
+		return
+	}
 
-		processedAnyCSV := false
-		for _, f := range zr.File {
-			if !strings.HasSuffix(strings.ToLower(f.Name), ".csv") {
-				continue
-			}
+	if err := timeDBQuery("commit", tx.Commit); err != nil {
+		logger.Error("commit failed", "error", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
 
-			rc, err := f.Open()
-			if err != nil {
-				log.Printf("Open zip file error: %v", err)
-				continue
-			}
+	rowsIngestedTotal.Add(float64(result.inserted))
+	rowsRejectedTotal.WithLabelValues("duplicate").Add(float64(result.staged - result.inserted))
 
-			err = processCSV(rc, &totalRowsProcessed, &totalItemsInserted, &duplicatesCount,
-				&totalPrice, categories, seenItems)
-			rc.Close()
+	response := Stats{
+		TotalCount:      result.rowsProcessed,
+		DuplicatesCount: result.staged - result.inserted,
+		TotalItems:      result.inserted,
+		TotalCategories: len(result.categories),
+		TotalPrice:      result.totalPrice,
+	}
 
-			if err != nil {
-				log.Printf("Process CSV error: %v", err)
-				http.Error(w, "csv processing error", http.StatusBadRequest)
-				return
-			}
-			processedAnyCSV = true
-		}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Error("encode response failed", "error", err)
+	}
+}
 
-		if !processedAnyCSV {
-			http.Error(w, "no csv files found", http.StatusBadRequest)
-			return
-		}
-	} else if queryType == "tar" {
-		// Обработка TAR архива (для уровня 2)
-		tr := tar.NewReader(bytes.NewReader(body))
-		processedAnyCSV := false
-
-		for {
-			header, err := tr.Next()
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				log.Printf("TAR error: %v", err)
-				http.Error(w, "invalid tar", http.StatusBadRequest)
-				return
-			}
+// effectiveArchiveType resolves the same type auto-detection handlePost/ingestArchive
+// use, purely for labeling the prices_archive_bytes_total metric.
+func effectiveArchiveType(queryType string, src archiveSource) string {
+	if queryType != "" {
+		return queryType
+	}
+	if detected := detectArchiveType(src); detected != "" {
+		return detected
+	}
+	return "unknown"
+}
 
-			if strings.HasSuffix(strings.ToLower(header.Name), ".csv") {
-				err = processCSV(tr, &totalRowsProcessed, &totalItemsInserted, &duplicatesCount,
-					&totalPrice, categories, seenItems)
-				if err != nil {
-					log.Printf("Process CSV error: %v", err)
-					http.Error(w, "csv processing error", http.StatusBadRequest)
+// ingestResult carries everything handlePost/runIngestJob need out of ingestArchive.
+type ingestResult struct {
+	rowsProcessed int
+	staged        int
+	inserted      int
+	totalPrice    int
+	categories    map[string]bool
+	internal      bool // true if the failure was a db/internal error rather than bad input
+}
+
+// ingestArchive stages every CSV file of the given archive into a per-transaction
+// temp table via COPY, then merges it into prices. The caller owns Commit/Rollback,
+// so the same code path serves both the synchronous POST handler and async jobs.
+// src is a random-access view of the archive (an in-memory buffer for direct POSTs,
+// or a disk-backed file for reassembled resumable uploads) so ingestArchive never has
+// to assume the whole archive already lives in a []byte. onProgress, when non-nil, is
+// called periodically with the rows seen so far.
+func ingestArchive(ctx context.Context, tx *sql.Tx, queryType string, src archiveSource, onProgress func(rowsProcessed, staged int)) (ingestResult, error) {
+	var result ingestResult
+	result.categories = make(map[string]bool)
+
+	if _, err := tx.Exec(`CREATE TEMP TABLE prices_staging (
+		name TEXT NOT NULL,
+		category TEXT NOT NULL,
+		price INTEGER NOT NULL,
+		create_date DATE
+	) ON COMMIT DROP`); err != nil {
+		result.internal = true
+		return result, fmt.Errorf("create staging table: %w", err)
+	}
+
+	copyStmt, err := tx.Prepare(pq.CopyIn("prices_staging", "name", "category", "price", "create_date"))
+	if err != nil {
+		result.internal = true
+		return result, fmt.Errorf("prepare copy: %w", err)
+	}
+
+	if queryType == "" {
+		queryType = detectArchiveType(src)
+	}
+
+	it, err := newArchiveIterator(queryType, src)
+	if err != nil {
+		return result, fmt.Errorf("invalid archive: %w", err)
+	}
+
+	var progressDone chan struct{}
+	if onProgress != nil {
+		progressDone = make(chan struct{})
+		go func() {
+			ticker := time.NewTicker(500 * time.Millisecond)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					onProgress(result.rowsProcessed, result.staged)
+				case <-progressDone:
 					return
 				}
-				processedAnyCSV = true
 			}
+		}()
+		defer close(progressDone)
+	}
+
+	processedAnyCSV := false
+	for {
+		name, rc, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, fmt.Errorf("invalid archive: %w", err)
 		}
 
-		if !processedAnyCSV {
-			http.Error(w, "no csv files found", http.StatusBadRequest)
-			return
+		err = processCSV(ctx, rc, copyStmt, &result.rowsProcessed, &result.staged, &result.totalPrice, result.categories)
+		rc.Close()
+
+		if err != nil {
+			return result, fmt.Errorf("csv processing error (%s): %w", name, err)
 		}
-	} else {
-		http.Error(w, "unsupported archive type", http.StatusBadRequest)
-		return
+		processedAnyCSV = true
 	}
 
-	response := Stats{
-		TotalCount:      totalRowsProcessed,
-		DuplicatesCount: duplicatesCount,
-		TotalItems:      totalItemsInserted,
-		TotalCategories: len(categories),
-		TotalPrice:      totalPrice,
+	if !processedAnyCSV {
+		return result, fmt.Errorf("no csv files found")
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("JSON encode error: %v", err)
+	// Сбрасываем буфер COPY и закрываем протокол bulk-копирования
+	if _, err := copyStmt.Exec(); err != nil {
+		result.internal = true
+		return result, fmt.Errorf("copy flush: %w", err)
 	}
+	if err := copyStmt.Close(); err != nil {
+		result.internal = true
+		return result, fmt.Errorf("copy close: %w", err)
+	}
+
+	// Мёрджим staging в основную таблицу, конфликтующие строки считаем дубликатами.
+	// Конфликт матчим по тому же COALESCE(create_date, '-infinity'), что и в
+	// prices_name_category_date_coalesce_idx, иначе строки с NULL-датой не ловятся.
+	var res sql.Result
+	err = timeDBQuery("merge_staging", func() error {
+		res, err = tx.Exec(`
+			INSERT INTO prices (name, category, price, create_date)
+			SELECT name, category, price, create_date FROM prices_staging
+			ON CONFLICT (name, category, (COALESCE(create_date, '-infinity'::date))) DO NOTHING`)
+		return err
+	})
+	if err != nil {
+		result.internal = true
+		return result, fmt.Errorf("merge staging: %w", err)
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		result.internal = true
+		return result, fmt.Errorf("rows affected: %w", err)
+	}
+	result.inserted = int(inserted)
+
+	return result, nil
 }
 
-func processCSV(r io.Reader, totalRowsProcessed, totalItemsInserted, duplicatesCount *int,
-	totalPrice *int, categories map[string]bool, seenItems map[string]bool) error {
+// processCSV парсит один CSV-файл и стримит валидные строки в открытый COPY-стейтмент
+// staging-таблицы. Вызывается один раз на файл внутри архива, стейтмент общий на весь запрос.
+func processCSV(ctx context.Context, r io.Reader, copyStmt *sql.Stmt, totalRowsProcessed, totalStaged *int,
+	totalPrice *int, categories map[string]bool) error {
 
 	reader := csv.NewReader(r)
 	reader.Comma = ','
@@ -317,7 +439,7 @@ func processCSV(r io.Reader, totalRowsProcessed, totalItemsInserted, duplicatesC
 
 	// Проверяем, что нашли необходимые колонки
 	if nameIdx == -1 || categoryIdx == -1 || priceIdx == -1 {
-		log.Printf("Warning: Required columns not found. Using default indices. Headers: %v", headers)
+		loggerFrom(ctx).Warn("required csv columns not found, using default indices", "headers", headers)
 		// Используем дефолтные индексы
 		if len(headers) >= 4 {
 			nameIdx = 1
@@ -329,56 +451,113 @@ func processCSV(r io.Reader, totalRowsProcessed, totalItemsInserted, duplicatesC
 		}
 	}
 
-	// Обрабатываем строки
+	// Декодирование CSV остаётся в этой горутине, а валидация и COPY раскладываются
+	// по worker pool'у: numWorkers горутин валидируют строки параллельно и шлют
+	// результат единственной горутине-писателю, которая одна пользуется copyStmt
+	// (протокол COPY не рассчитан на конкурентный доступ). Оба канала ограничены,
+	// так что медленный writer создаёт обратное давление на декодирование.
+	numWorkers := runtime.GOMAXPROCS(0)
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+
+	rawRows := make(chan []string, numWorkers*4)
+	valid := make(chan validRow, numWorkers*4)
+
+	var workers sync.WaitGroup
+	workers.Add(numWorkers)
+	for i := 0; i < numWorkers; i++ {
+		go func() {
+			defer workers.Done()
+			for row := range rawRows {
+				if len(row) <= nameIdx || len(row) <= categoryIdx || len(row) <= priceIdx {
+					rowsRejectedTotal.WithLabelValues("short_row").Inc()
+					continue
+				}
+
+				name := strings.TrimSpace(row[nameIdx])
+				category := strings.TrimSpace(row[categoryIdx])
+				priceStr := strings.TrimSpace(row[priceIdx])
+				if name == "" || category == "" || priceStr == "" {
+					rowsRejectedTotal.WithLabelValues("empty_field").Inc()
+					continue
+				}
+
+				price, err := strconv.Atoi(priceStr)
+				if err != nil {
+					// Для уровня 3: пропускаем некорректные цены
+					rowsRejectedTotal.WithLabelValues("invalid_price").Inc()
+					continue
+				}
+
+				var createDate interface{}
+				if dateIdx != -1 && len(row) > dateIdx {
+					if d := strings.TrimSpace(row[dateIdx]); d != "" {
+						createDate = d
+					}
+				}
+
+				valid <- validRow{name: name, category: category, price: price, createDate: createDate}
+			}
+		}()
+	}
+
+	writerDone := make(chan error, 1)
+	go func() {
+		for row := range valid {
+			if _, err := copyStmt.Exec(row.name, row.category, row.price, row.createDate); err != nil {
+				writerDone <- fmt.Errorf("copy exec error: %v", err)
+				// Дочитываем оставшиеся строки, чтобы воркеры не заблокировались навсегда
+				for range valid {
+				}
+				return
+			}
+			*totalStaged++
+			*totalPrice += row.price
+			categories[row.category] = true
+		}
+		writerDone <- nil
+	}()
+
+	var readErr error
 	for {
 		row, err := reader.Read()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			log.Printf("CSV read error: %v", err)
-			continue
+			readErr = fmt.Errorf("malformed csv row: %v", err)
+			break
 		}
 
 		*totalRowsProcessed++
+		rawRows <- row
+	}
+	close(rawRows)
+	workers.Wait()
+	close(valid)
 
-		// Проверяем, что строка имеет достаточно колонок
-		if len(row) <= nameIdx || len(row) <= categoryIdx || len(row) <= priceIdx {
-			continue
-		}
-
-		name := strings.TrimSpace(row[nameIdx])
-		category := strings.TrimSpace(row[categoryIdx])
-		priceStr := strings.TrimSpace(row[priceIdx])
-
-		// Пропускаем пустые значения
-		if name == "" || category == "" || priceStr == "" {
-			continue
-		}
-
-		// Парсим цену
-		price, err := strconv.Atoi(priceStr)
-		if err != nil {
-			// Для уровня 3: пропускаем некорректные цены
-			continue
-		}
-
-		*totalItemsInserted++
-		*totalPrice += price
-		categories[category] = true
+	if writeErr := <-writerDone; writeErr != nil {
+		return writeErr
 	}
+	return readErr
+}
 
-	return nil
+// validRow is a CSV row that has passed validation and is ready to stream into COPY.
+type validRow struct {
+	name, category string
+	price          int
+	createDate     interface{}
 }
 
-func handleGet(w http.ResponseWriter, r *http.Request) {
-	// Получаем параметры фильтрации
+// buildPricesQuery assembles the filtered SELECT shared by handleGet and handleReport's
+// row-level callers. Kept here since handleGet owns the filter query params.
+func buildPricesQuery(r *http.Request) (string, []interface{}) {
 	startDate := r.URL.Query().Get("start")
 	endDate := r.URL.Query().Get("end")
 	minPrice := r.URL.Query().Get("min")
 	maxPrice := r.URL.Query().Get("max")
 
-	// Строим SQL запрос с фильтрами
 	query := "SELECT name, category, price, create_date FROM prices WHERE 1=1"
 	args := []interface{}{}
 	argIdx := 1
@@ -413,82 +592,5 @@ func handleGet(w http.ResponseWriter, r *http.Request) {
 	}
 
 	query += " ORDER BY id"
-
-	log.Printf("Executing query: %s with args: %v", query, args)
-
-	rows, err := db.Query(query, args...)
-	if err != nil {
-		log.Printf("DB query error: %v", err)
-		// Возвращаем пустой архив вместо ошибки
-		returnEmptyZip(w)
-		return
-	}
-	defer rows.Close()
-
-	var buf bytes.Buffer
-	zipWriter := zip.NewWriter(&buf)
-	csvFile, err := zipWriter.Create("data.csv")
-	if err != nil {
-		log.Printf("Create zip file error: %v", err)
-		returnEmptyZip(w)
-		return
-	}
-
-	csvWriter := csv.NewWriter(csvFile)
-
-	// Записываем заголовок
-	if err := csvWriter.Write([]string{"name", "category", "price", "create_date"}); err != nil {
-		log.Printf("Write CSV header error: %v", err)
-	}
-
-	rowCount := 0
-	for rows.Next() {
-		var name, category string
-		var price int
-		var createDate sql.NullTime
-
-		if err := rows.Scan(&name, &category, &price, &createDate); err != nil {
-			log.Printf("Row scan error: %v", err)
-			continue
-		}
-
-		dateStr := ""
-		if createDate.Valid {
-			dateStr = createDate.Time.Format("2006-01-02")
-		}
-
-		if err := csvWriter.Write([]string{name, category, strconv.Itoa(price), dateStr}); err != nil {
-			log.Printf("Write CSV row error: %v", err)
-		}
-		rowCount++
-	}
-
-	if err = rows.Err(); err != nil {
-		log.Printf("Rows iteration error: %v", err)
-	}
-
-	csvWriter.Flush()
-	if err := zipWriter.Close(); err != nil {
-		log.Printf("Close zip error: %v", err)
-	}
-
-	log.Printf("Exported %d rows", rowCount)
-
-	w.Header().Set("Content-Type", "application/zip")
-	w.Header().Set("Content-Disposition", "attachment; filename=data.zip")
-	w.Write(buf.Bytes())
-}
-
-func returnEmptyZip(w http.ResponseWriter) {
-	var buf bytes.Buffer
-	zipWriter := zip.NewWriter(&buf)
-	csvFile, _ := zipWriter.Create("data.csv")
-	csvWriter := csv.NewWriter(csvFile)
-	csvWriter.Write([]string{"name", "category", "price", "create_date"})
-	csvWriter.Flush()
-	zipWriter.Close()
-
-	w.Header().Set("Content-Type", "application/zip")
-	w.Header().Set("Content-Disposition", "attachment; filename=data.zip")
-	w.Write(buf.Bytes())
+	return query, args
 }
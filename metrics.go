@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "prices_http_requests_total",
+		Help: "Total HTTP requests handled by the prices API.",
+	}, []string{"method", "status"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "prices_http_request_duration_seconds",
+		Help:    "HTTP request latency in seconds.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "path"})
+
+	rowsIngestedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "prices_rows_ingested_total",
+		Help: "Total CSV rows committed into the prices table.",
+	})
+
+	rowsRejectedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "prices_rows_rejected_total",
+		Help: "Total CSV rows rejected during ingestion, by reason.",
+	}, []string{"reason"})
+
+	archiveBytesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "prices_archive_bytes_total",
+		Help: "Total bytes of uploaded archives, by format.",
+	}, []string{"format"})
+
+	dbQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "prices_db_query_duration_seconds",
+		Help:    "Database query latency in seconds, by operation.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"op"})
+)
+
+// timeDBQuery wraps a db call with a prices_db_query_duration_seconds observation.
+func timeDBQuery(op string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	dbQueryDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	return err
+}
+
+// metricsHandler exposes /metrics for Prometheus scraping.
+var metricsHandler = promhttp.Handler()
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	metricsHandler.ServeHTTP(w, r)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+type ctxKey string
+
+const requestIDKey ctxKey = "request_id"
+
+// requestIDFrom returns the request-scoped id stashed in ctx by withLogging, or ""
+// outside a request (e.g. background jobs started from an already-returned handler).
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// loggerFrom returns a logger carrying the request's id as a structured attribute.
+func loggerFrom(ctx context.Context) *slog.Logger {
+	return slog.Default().With("request_id", requestIDFrom(ctx))
+}
+
+// metricPath collapses a request path to its registered route pattern for use as a
+// metric label. /api/v0/prices/jobs/{id} and /api/v0/prices/uploads/{id} each mint a
+// fresh id per request, so labeling with the raw path would give prices_http_request_duration_seconds
+// unbounded cardinality — one time series per job/upload ever created.
+func metricPath(path string) string {
+	switch {
+	case strings.HasPrefix(path, "/api/v0/prices/jobs/"):
+		return "/api/v0/prices/jobs/{id}"
+	case strings.HasPrefix(path, "/api/v0/prices/uploads/"):
+		return "/api/v0/prices/uploads/{id}"
+	default:
+		return path
+	}
+}
+
+// statusRecorder captures the status code and byte count of a response so the
+// access log can report them after the handler returns.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+// Flush forwards to the underlying ResponseWriter's http.Flusher when present, so
+// wrapping a handler in withLogging doesn't silently break handlers (like handleGet)
+// that type-assert w.(http.Flusher) to stream large responses without buffering.
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// withLogging wraps a handler with an Apache-combined-style structured access log
+// and the prices_http_requests_total/prices_http_request_duration_seconds metrics.
+// It also assigns each request an id (from X-Request-ID, or a generated ULID) and
+// carries it through r.Context() so downstream code can log with the same id.
+func withLogging(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-ID")
+		if requestID == "" {
+			requestID = ulid.Make().String()
+		}
+
+		ctx := context.WithValue(r.Context(), requestIDKey, requestID)
+		r = r.WithContext(ctx)
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+
+		next(rec, r)
+
+		duration := time.Since(start)
+		httpRequestsTotal.WithLabelValues(r.Method, http.StatusText(rec.status)).Inc()
+		httpRequestDuration.WithLabelValues(r.Method, metricPath(r.URL.Path)).Observe(duration.Seconds())
+
+		slog.Default().With("request_id", requestID).Info("http_access",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"duration_ms", duration.Milliseconds(),
+			"remote_addr", r.RemoteAddr,
+			"user_agent", r.UserAgent(),
+		)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMetricPathCollapsesIDs(t *testing.T) {
+	cases := map[string]string{
+		"/api/v0/prices":                "/api/v0/prices",
+		"/api/v0/prices/report":         "/api/v0/prices/report",
+		"/api/v0/prices/jobs/abc123":    "/api/v0/prices/jobs/{id}",
+		"/api/v0/prices/uploads/def456": "/api/v0/prices/uploads/{id}",
+		"/api/v0/prices/uploads/":       "/api/v0/prices/uploads/{id}",
+		"/metrics":                      "/metrics",
+	}
+	for path, want := range cases {
+		if got := metricPath(path); got != want {
+			t.Errorf("metricPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+// TestStatusRecorderFlush guards against statusRecorder silently dropping streaming:
+// httptest.NewRecorder implements http.Flusher, so a statusRecorder wrapping one must
+// satisfy http.Flusher too and must forward Flush to it.
+func TestStatusRecorderFlush(t *testing.T) {
+	underlying := httptest.NewRecorder()
+	rec := &statusRecorder{ResponseWriter: underlying, status: http.StatusOK}
+
+	flusher, ok := (http.ResponseWriter)(rec).(http.Flusher)
+	if !ok {
+		t.Fatal("statusRecorder does not implement http.Flusher")
+	}
+	flusher.Flush()
+
+	if !underlying.Flushed {
+		t.Error("statusRecorder.Flush did not forward to the underlying ResponseWriter")
+	}
+}
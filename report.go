@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// allowedGroupBy and allowedMetric whitelist the only identifiers that may be
+// interpolated into the report SQL, since group_by/metric can't be passed as
+// query placeholders.
+var allowedGroupBy = map[string]string{
+	"category":       "category",
+	"day":            "date_trunc('day', create_date)",
+	"week":           "date_trunc('week', create_date)",
+	"month":          "date_trunc('month', create_date)",
+	"category+month": "category, date_trunc('month', create_date)",
+}
+
+var allowedMetric = map[string]string{
+	"sum":    "SUM(price)",
+	"avg":    "AVG(price)",
+	"min":    "MIN(price)",
+	"max":    "MAX(price)",
+	"count":  "COUNT(*)",
+	"stddev": "STDDEV(price)",
+}
+
+// normalizeGroupBy resolves a group_by query value to its whitelisted SQL expression.
+// net/url decodes an unencoded "+" in a query value to a space (application/x-www-form-urlencoded
+// rules), so the documented ?group_by=category+month arrives at Query().Get as "category month" —
+// that form is accepted as an alias of "category+month" before falling back to not-found.
+func normalizeGroupBy(groupBy string) (string, bool) {
+	if groupBy == "" {
+		groupBy = "category"
+	}
+	if expr, ok := allowedGroupBy[groupBy]; ok {
+		return expr, true
+	}
+	expr, ok := allowedGroupBy[strings.ReplaceAll(groupBy, " ", "+")]
+	return expr, ok
+}
+
+// percentileAlias turns a percentile fraction into a legal, collision-free Postgres column
+// alias. Formatting v*100 directly (e.g. "p93.7") isn't a legal unquoted identifier for any
+// percentile whose *100 isn't a whole number — the bare "." gets parsed as qualification and
+// the query fails with a syntax error.
+func percentileAlias(v float64) string {
+	return fmt.Sprintf("p%d", int(math.Round(v*1000)))
+}
+
+// handleReport serves GET /api/v0/prices/report: aggregate stats computed in SQL
+// instead of dumping individual rows.
+func handleReport(w http.ResponseWriter, r *http.Request) {
+	logger := loggerFrom(r.Context())
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	groupBy := r.URL.Query().Get("group_by")
+	groupExpr, ok := normalizeGroupBy(groupBy)
+	if !ok {
+		http.Error(w, fmt.Sprintf("unsupported group_by %q", groupBy), http.StatusBadRequest)
+		return
+	}
+
+	metricParam := r.URL.Query().Get("metric")
+	if metricParam == "" {
+		metricParam = "sum,count"
+	}
+	var metrics []string
+	var metricExprs []string
+	for _, m := range strings.Split(metricParam, ",") {
+		m = strings.TrimSpace(m)
+		expr, ok := allowedMetric[m]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unsupported metric %q", m), http.StatusBadRequest)
+			return
+		}
+		metrics = append(metrics, m)
+		metricExprs = append(metricExprs, fmt.Sprintf("%s AS %s", expr, m))
+	}
+
+	var percentiles []float64
+	var percentileExprs []string
+	if p := r.URL.Query().Get("percentile"); p != "" {
+		for _, raw := range strings.Split(p, ",") {
+			v, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+			if err != nil || v < 0 || v > 1 {
+				http.Error(w, fmt.Sprintf("invalid percentile %q", raw), http.StatusBadRequest)
+				return
+			}
+			percentiles = append(percentiles, v)
+			percentileExprs = append(percentileExprs, fmt.Sprintf(
+				"percentile_cont(%g) WITHIN GROUP (ORDER BY price) AS %s", v, percentileAlias(v)))
+		}
+	}
+
+	// Plain "category" is already a real column name, so select it bare rather than
+	// aliasing it to "bucket" — that keeps the response's dimension key consistent
+	// with category+month, which (via the embedded comma in its groupExpr) already
+	// emits "category" as its own field alongside "bucket" for the month part.
+	var selectCols []string
+	bucketCol := "bucket"
+	if groupExpr == allowedGroupBy["category"] {
+		selectCols = []string{groupExpr}
+		bucketCol = groupExpr
+	} else {
+		selectCols = []string{groupExpr + " AS bucket"}
+	}
+	selectCols = append(selectCols, metricExprs...)
+	selectCols = append(selectCols, percentileExprs...)
+
+	query := "SELECT " + strings.Join(selectCols, ", ") + " FROM prices WHERE 1=1"
+	var args []interface{}
+	argIdx := 1
+
+	if start := r.URL.Query().Get("start"); start != "" {
+		query += fmt.Sprintf(" AND create_date >= $%d", argIdx)
+		args = append(args, start)
+		argIdx++
+	}
+	if end := r.URL.Query().Get("end"); end != "" {
+		query += fmt.Sprintf(" AND create_date <= $%d", argIdx)
+		args = append(args, end)
+		argIdx++
+	}
+	if min := r.URL.Query().Get("min"); min != "" {
+		if v, err := strconv.Atoi(min); err == nil {
+			query += fmt.Sprintf(" AND price >= $%d", argIdx)
+			args = append(args, v)
+			argIdx++
+		}
+	}
+	if max := r.URL.Query().Get("max"); max != "" {
+		if v, err := strconv.Atoi(max); err == nil {
+			query += fmt.Sprintf(" AND price <= $%d", argIdx)
+			args = append(args, v)
+			argIdx++
+		}
+	}
+
+	query += " GROUP BY " + groupExpr + " ORDER BY " + bucketCol
+
+	logger.Info("executing report query", "query", query, "args", args)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		logger.Error("report query failed", "error", err)
+		http.Error(w, "query error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		logger.Error("report columns failed", "error", err)
+		http.Error(w, "query error", http.StatusInternalServerError)
+		return
+	}
+
+	var buckets []map[string]interface{}
+	for rows.Next() {
+		vals := make([]interface{}, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			logger.Error("report row scan failed", "error", err)
+			continue
+		}
+
+		bucket := make(map[string]interface{}, len(cols))
+		for i, col := range cols {
+			bucket[col] = normalizeReportValue(vals[i])
+		}
+		buckets = append(buckets, bucket)
+	}
+	if err := rows.Err(); err != nil {
+		logger.Error("report rows iteration failed", "error", err)
+	}
+
+	if strings.ToLower(r.URL.Query().Get("format")) == "csv" {
+		writeReportCSV(w, logger, cols, buckets)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(buckets); err != nil {
+		logger.Error("report json encode failed", "error", err)
+	}
+}
+
+// normalizeReportValue coerces driver-returned []byte (numeric/date types come back
+// that way over the postgres wire) into JSON-friendly strings/numbers.
+func normalizeReportValue(v interface{}) interface{} {
+	b, ok := v.([]byte)
+	if !ok {
+		return v
+	}
+	s := string(b)
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	return s
+}
+
+func writeReportCSV(w http.ResponseWriter, logger *slog.Logger, cols []string, buckets []map[string]interface{}) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=report.csv")
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(cols); err != nil {
+		logger.Error("write report csv header failed", "error", err)
+	}
+	for _, bucket := range buckets {
+		row := make([]string, len(cols))
+		for i, col := range cols {
+			row[i] = fmt.Sprintf("%v", bucket[col])
+		}
+		if err := cw.Write(row); err != nil {
+			logger.Error("write report csv row failed", "error", err)
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		logger.Error("report csv flush failed", "error", err)
+	}
+}
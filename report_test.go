@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestNormalizeGroupBy(t *testing.T) {
+	cases := []struct {
+		name     string
+		groupBy  string
+		wantExpr string
+		wantOK   bool
+	}{
+		{"default empty", "", allowedGroupBy["category"], true},
+		{"plain key", "month", allowedGroupBy["month"], true},
+		{"literal plus preserved", "category+month", allowedGroupBy["category+month"], true},
+		{"space decoded from unencoded plus", "category month", allowedGroupBy["category+month"], true},
+		{"unknown key", "category week", "", false},
+		{"sql injection attempt", "category; DROP TABLE prices;--", "", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			expr, ok := normalizeGroupBy(tc.groupBy)
+			if ok != tc.wantOK {
+				t.Fatalf("normalizeGroupBy(%q) ok = %v, want %v", tc.groupBy, ok, tc.wantOK)
+			}
+			if ok && expr != tc.wantExpr {
+				t.Fatalf("normalizeGroupBy(%q) = %q, want %q", tc.groupBy, expr, tc.wantExpr)
+			}
+		})
+	}
+}
+
+func TestPercentileAlias(t *testing.T) {
+	cases := []struct {
+		v    float64
+		want string
+	}{
+		{0.5, "p500"},
+		{0.9, "p900"},
+		{0.99, "p990"},
+		{0.937, "p937"},
+		{0.995, "p995"},
+	}
+
+	for _, tc := range cases {
+		if got := percentileAlias(tc.v); got != tc.want {
+			t.Errorf("percentileAlias(%v) = %q, want %q", tc.v, got, tc.want)
+		}
+	}
+}
+
+// TestHandleReportGroupByCategoryEmitsCategoryKey pins down the response shape for a plain
+// group_by=category report: the grouped column must come through as its own "category" key,
+// not the generic "bucket" alias used by the date-bucketed group_by values, so a single-
+// dimension category report and a category+month report expose "category" the same way.
+func TestHandleReportGroupByCategoryEmitsCategoryKey(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer mockDB.Close()
+	origDB := db
+	db = mockDB
+	defer func() { db = origDB }()
+
+	mock.ExpectQuery(regexp.QuoteMeta(
+		"SELECT category, SUM(price) AS sum, COUNT(*) AS count FROM prices WHERE 1=1 GROUP BY category ORDER BY category")).
+		WillReturnRows(sqlmock.NewRows([]string{"category", "sum", "count"}).
+			AddRow("Fruit", 150, 2))
+
+	req := httptest.NewRequest("GET", "/api/v0/prices/report?group_by=category", nil)
+	rec := httptest.NewRecorder()
+	handleReport(rec, req)
+
+	var buckets []map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &buckets); err != nil {
+		t.Fatalf("decode response: %v (body: %s)", err, rec.Body.String())
+	}
+	if len(buckets) != 1 {
+		t.Fatalf("buckets = %d, want 1", len(buckets))
+	}
+	if _, ok := buckets[0]["category"]; !ok {
+		t.Errorf("expected response bucket to have a %q key, got %v", "category", buckets[0])
+	}
+	if _, ok := buckets[0]["bucket"]; ok {
+		t.Errorf("expected group_by=category response to not have a generic %q key, got %v", "bucket", buckets[0])
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+// TestHandleReportGroupByMonthEmitsBucketKey pins down the complementary case: a date-bucketed
+// group_by (no raw column to select bare) still uses the generic "bucket" key.
+func TestHandleReportGroupByMonthEmitsBucketKey(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer mockDB.Close()
+	origDB := db
+	db = mockDB
+	defer func() { db = origDB }()
+
+	mock.ExpectQuery(regexp.QuoteMeta(
+		"SELECT date_trunc('month', create_date) AS bucket, SUM(price) AS sum, COUNT(*) AS count FROM prices WHERE 1=1 GROUP BY date_trunc('month', create_date) ORDER BY bucket")).
+		WillReturnRows(sqlmock.NewRows([]string{"bucket", "sum", "count"}).
+			AddRow("2024-01-01", 150, 2))
+
+	req := httptest.NewRequest("GET", "/api/v0/prices/report?group_by=month", nil)
+	rec := httptest.NewRecorder()
+	handleReport(rec, req)
+
+	var buckets []map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &buckets); err != nil {
+		t.Fatalf("decode response: %v (body: %s)", err, rec.Body.String())
+	}
+	if len(buckets) != 1 {
+		t.Fatalf("buckets = %d, want 1", len(buckets))
+	}
+	if _, ok := buckets[0]["bucket"]; !ok {
+		t.Errorf("expected response bucket to have a %q key, got %v", "bucket", buckets[0])
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+// TestAllowedMetricWhitelist guards against accidentally interpolating caller-controlled
+// identifiers: only the exact whitelisted keys may resolve to a SQL expression.
+func TestAllowedMetricWhitelist(t *testing.T) {
+	injectionAttempts := []string{
+		"sum); DROP TABLE prices;--",
+		"price",
+		"SUM(price); SELECT",
+		"",
+	}
+	for _, attempt := range injectionAttempts {
+		if _, ok := allowedMetric[attempt]; ok {
+			t.Errorf("allowedMetric unexpectedly matched %q", attempt)
+		}
+	}
+}
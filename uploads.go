@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// upload tracks one resumable upload following the core of the tus.io protocol
+// (Upload-Length/Upload-Offset headers, PATCH with application/offset+octet-stream),
+// so a dropped connection on a multi-GB archive doesn't force a full restart.
+type upload struct {
+	mu        sync.Mutex
+	length    int64
+	offset    int64
+	path      string
+	queryType string
+}
+
+var (
+	uploadsMu sync.Mutex
+	uploads   = map[string]*upload{}
+)
+
+func uploadDir() string {
+	dir := filepath.Join(os.TempDir(), "prices-uploads")
+	os.MkdirAll(dir, 0o755)
+	return dir
+}
+
+// handleCreateUpload serves POST /api/v0/prices/uploads, the tus "creation" step.
+func handleCreateUpload(w http.ResponseWriter, r *http.Request) {
+	logger := loggerFrom(r.Context())
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "missing or invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+
+	id := newJobID()
+	path := filepath.Join(uploadDir(), id)
+	f, err := os.Create(path)
+	if err != nil {
+		logger.Error("create upload file failed", "error", err)
+		http.Error(w, "storage error", http.StatusInternalServerError)
+		return
+	}
+	f.Close()
+
+	u := &upload{length: length, path: path, queryType: r.URL.Query().Get("type")}
+	uploadsMu.Lock()
+	uploads[id] = u
+	uploadsMu.Unlock()
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v0/prices/uploads/%s", id))
+	w.Header().Set("Upload-Offset", "0")
+	w.WriteHeader(http.StatusCreated)
+}
+
+// handleUploadChunk serves PATCH /api/v0/prices/uploads/{id}: one resumable chunk.
+// Once Upload-Offset reaches Upload-Length the archive is handed off to the same
+// async ingestion path as POST /api/v0/prices?async=true.
+func handleUploadChunk(w http.ResponseWriter, r *http.Request) {
+	logger := loggerFrom(r.Context())
+
+	if r.Method != http.MethodPatch {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "unsupported Content-Type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/v0/prices/uploads/")
+	uploadsMu.Lock()
+	u, ok := uploads[id]
+	uploadsMu.Unlock()
+	if !ok {
+		http.Error(w, "upload not found", http.StatusNotFound)
+		return
+	}
+
+	clientOffset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		http.Error(w, "missing or invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if clientOffset != u.offset {
+		http.Error(w, "offset mismatch", http.StatusConflict)
+		return
+	}
+
+	f, err := os.OpenFile(u.path, os.O_WRONLY, 0o644)
+	if err != nil {
+		logger.Error("open upload file failed", "error", err)
+		http.Error(w, "storage error", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(u.offset, io.SeekStart); err != nil {
+		logger.Error("seek upload file failed", "error", err)
+		http.Error(w, "storage error", http.StatusInternalServerError)
+		return
+	}
+
+	n, err := io.Copy(f, r.Body)
+	if err != nil {
+		logger.Error("write upload chunk failed", "error", err)
+		http.Error(w, "storage error", http.StatusInternalServerError)
+		return
+	}
+	u.offset += n
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.offset, 10))
+
+	if u.offset < u.length {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// Загрузка завершена — запускаем ту же асинхронную обработку, что и ?async=true.
+	// Открываем файл вместо os.ReadFile: весь смысл resumable-загрузки в том, чтобы
+	// не держать многогигабайтный архив целиком в памяти, поэтому ingestArchive читает
+	// его прямо с диска через archiveSource, а не из буфера.
+	path := u.path
+	uf, err := os.Open(path)
+	if err != nil {
+		logger.Error("open completed upload failed", "error", err)
+		http.Error(w, "storage error", http.StatusInternalServerError)
+		return
+	}
+	uploadsMu.Lock()
+	delete(uploads, id)
+	uploadsMu.Unlock()
+
+	src := fileArchiveSource{File: uf, size: u.offset}
+	job := startIngestJob(u.queryType, src, func() {
+		uf.Close()
+		os.Remove(path)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, `{"job_id":%q}`, job.ID)
+}
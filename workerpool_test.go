@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestProcessCSVWorkerPoolRejectsBadRows drives processCSV's worker-pool pipeline
+// directly (decode -> validate workers -> single writer) against a mocked copyStmt,
+// covering all three rejection reasons alongside the happy path.
+func TestProcessCSVWorkerPoolRejectsBadRows(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer mockDB.Close()
+	mock.MatchExpectationsInOrder(false)
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare("INSERT INTO staging")
+	mock.ExpectExec("INSERT INTO staging").WithArgs("Apple", "Fruit", 100, nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	tx, err := mockDB.Begin()
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	stmt, err := tx.Prepare("INSERT INTO staging (name, category, price, create_date) VALUES ($1,$2,$3,$4)")
+	if err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+
+	csvContent := "name,category,price\n" +
+		"Apple,Fruit,100\n" + // valid
+		"Banana\n" + // short_row
+		",Veg,50\n" + // empty_field
+		"Carrot,Veg,notaprice\n" // invalid_price
+
+	var rowsProcessed, staged, totalPrice int
+	categories := map[string]bool{}
+	if err := processCSV(context.Background(), strings.NewReader(csvContent), stmt,
+		&rowsProcessed, &staged, &totalPrice, categories); err != nil {
+		t.Fatalf("processCSV: %v", err)
+	}
+
+	if rowsProcessed != 4 {
+		t.Errorf("rowsProcessed = %d, want 4", rowsProcessed)
+	}
+	if staged != 1 {
+		t.Errorf("staged = %d, want 1", staged)
+	}
+	if totalPrice != 100 {
+		t.Errorf("totalPrice = %d, want 100", totalPrice)
+	}
+	if !categories["Fruit"] {
+		t.Errorf("expected category Fruit to be recorded")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}